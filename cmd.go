@@ -1,10 +1,11 @@
 package cmdr
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
@@ -17,6 +18,10 @@ import (
 	"time"
 )
 
+// defaultGrace is how long a context-cancelled process is given to exit
+// after SIGINT before it is escalated to SIGKILL.
+const defaultGrace = 100 * time.Millisecond
+
 type Param [2]string
 
 // only the data needed to know how run a local command
@@ -24,21 +29,71 @@ type Command struct {
 	Path, Params string // path to executable, param template
 	Dir, User    string // optional working dir, user to run as
 	Async        bool
+
+	// Args, if non-empty, is used verbatim as argv and bypasses template
+	// rendering entirely, matching the ergonomics of exec.Command(name, argv...).
+	Args []string
+
+	// Stdout and Stderr, if set, receive the process's output as it is
+	// produced instead of being buffered into Runtime.Stdout/Stderr. When
+	// CombinedOutput is true, stderr is written to Stdout's sink as well and
+	// Stderr is ignored.
+	Stdout, Stderr io.Writer
+	CombinedOutput bool
+
+	// MaxOutputBytes caps how much of stdout/stderr (each counted
+	// independently) is written to a sink; the rest is discarded and
+	// Runtime.Truncated is set. Zero means unbounded.
+	MaxOutputBytes int64
+
+	// Timeout bounds how long the command may run; zero means no bound.
+	// On expiry (or if the caller's context is cancelled via RunContext)
+	// the process is sent SIGINT, given GracePeriod to exit, then SIGKILL.
+	Timeout time.Duration
+
+	// GracePeriod is how long to wait after SIGINT before escalating to
+	// SIGKILL. Zero means defaultGrace.
+	GracePeriod time.Duration
 }
 
-func (c Command) Render(params ...Param) (string, error) {
+// Render expands the param template into an argv, honoring quoting rules so
+// that a param value containing whitespace (e.g. Param{"MSG", "hello world"})
+// or a glob expansion that matches a file with spaces in its name stays a
+// single argument. Quoting only protects whitespace from tokenize, not glob
+// metacharacters from expansion: a param value containing "*", "?" or
+// "[...]" is still matched against the filesystem by optional, the same as
+// an unquoted one, unlike a real shell's double quotes.
+func (c Command) Render(params ...Param) ([]string, error) {
 	text := c.Params
 	for _, p := range params {
 		if len(p[0]) > 0 {
 			t := "{{" + p[0] + "}}"
-			text = strings.Replace(text, t, p[1], -1)
+			text = strings.Replace(text, t, quoteArg(p[1]), -1)
 		} else {
-			text += " " + p[1]
+			text += " " + quoteArg(p[1])
 		}
 	}
 	return optional(text)
 }
 
+// quoteArg double-quotes a param value before it is substituted into the
+// template, so that whitespace in the value (e.g. Param{"MSG", "hello
+// world"}) survives tokenize as a single argument rather than being split.
+// It does not shield glob metacharacters from the expansion optional does
+// after tokenizing; see Render.
+func quoteArg(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
 var (
 	mtx       sync.Mutex
 	sessionID int64
@@ -67,6 +122,20 @@ type Runtime struct {
 	Cmd, Stdout, Stderr  string
 	SystemTime, UserTime time.Duration
 	Started, Finished    time.Time
+
+	// Interrupted is true if the context driving the run was cancelled or
+	// timed out, causing a signal to be sent to the process.
+	Interrupted bool
+	// Killed is true if the process did not exit within GracePeriod of
+	// Interrupted and had to be escalated to SIGKILL.
+	Killed bool
+	// Signal is the last signal sent to the process as a result of
+	// cancellation, if any.
+	Signal syscall.Signal
+
+	// Truncated is true if stdout or stderr exceeded Command.MaxOutputBytes
+	// and was cut off.
+	Truncated bool
 }
 
 var rFormat = `
@@ -81,32 +150,35 @@ USR: %s
 CLK: %s
 `
 
-func readString(r io.Reader) (string, error) {
-	b, err := ioutil.ReadAll(r)
-	return string(b), err
-}
-
 func (r Runtime) String() string {
 	return fmt.Sprintf(rFormat, r.Cmd, r.SID, r.PID, r.RC, r.Stdout, r.Stderr, r.SystemTime, r.UserTime, r.Finished.Sub(r.Started))
 }
 
 func (c Command) Runner(rt chan Runtime, params ...Param) error {
+	return c.RunnerContext(context.Background(), rt, params...)
+}
+
+// RunnerContext is Runner with the addition that the process is interrupted
+// as soon as ctx is done or c.Timeout elapses, whichever comes first. On
+// cancellation the process is sent SIGINT, given c.GracePeriod (default
+// defaultGrace) to exit, then escalated to SIGKILL.
+func (c Command) RunnerContext(ctx context.Context, rt chan Runtime, params ...Param) error {
 	r := Runtime{}
+	// For an async command, r is also written by the background goroutine
+	// (via watch and finisher) after this function has returned, so every
+	// read or write of r below is guarded by rmu.
+	var rmu sync.Mutex
 	defer func() {
-		rt <- r
+		rmu.Lock()
+		snap := r
+		rmu.Unlock()
+		rt <- snap
 	}()
-	path, err := exec.LookPath(c.Path)
-	if err != nil {
-		return err
-	}
-	text, err := c.Render(params...)
+	path, argv, err := c.argv(params...)
 	if err != nil {
 		return err
 	}
-	r.Cmd = path
-	if len(text) > 0 {
-		r.Cmd += " " + text
-	}
+	r.Cmd = strings.Join(append([]string{path}, argv...), " ")
 
 	errOut, errIn, err := os.Pipe()
 	if err != nil {
@@ -142,69 +214,225 @@ func (c Command) Runner(rt chan Runtime, params ...Param) error {
 		creds := &syscall.Credential{Uid: uint32(uid)}
 		attr.Sys = &syscall.SysProcAttr{Credential: creds}
 	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
 	r.SID = nextID()
-	p, err := os.StartProcess(path, strings.Fields(r.Cmd), attr)
+	p, err := os.StartProcess(path, append([]string{path}, argv...), attr)
 	if err != nil {
 		return err
 	}
 	r.Started = time.Now()
 
+	// The parent only ever reads; close its copies of the write ends so
+	// the read ends see EOF once the child's copies close at exit.
+	outIn.Close()
+	errIn.Close()
+
+	var stdoutBuf, stderrBuf *bytes.Buffer
+	stdoutSink := c.Stdout
+	if stdoutSink == nil {
+		stdoutBuf = &bytes.Buffer{}
+		stdoutSink = stdoutBuf
+	}
+	stderrSink := c.Stderr
+	if c.CombinedOutput {
+		stderrSink = stdoutSink
+	} else if stderrSink == nil {
+		stderrBuf = &bytes.Buffer{}
+		stderrSink = stderrBuf
+	}
+
+	// When CombinedOutput is set, stdout and stderr share one sink, so they
+	// must also share one capWriter: otherwise each stream would get its
+	// own MaxOutputBytes allowance and up to twice the cap could reach the
+	// combined sink.
+	outCap := &capWriter{w: stdoutSink, max: c.MaxOutputBytes}
+	errCap := outCap
+	if !c.CombinedOutput {
+		errCap = &capWriter{w: stderrSink, max: c.MaxOutputBytes}
+	}
+
+	var copyWG sync.WaitGroup
+	copyWG.Add(2)
+	go func() {
+		defer copyWG.Done()
+		io.Copy(outCap, outOut)
+	}()
+	go func() {
+		defer copyWG.Done()
+		io.Copy(errCap, errOut)
+	}()
+
+	waited := make(chan *os.ProcessState, 1)
+	go func() {
+		s, _ := p.Wait()
+		waited <- s
+	}()
+
 	finisher := func() error {
-		s, err := p.Wait()
-		r.Finished = time.Now()
+		s := <-waited
+		copyWG.Wait()
 		if c.Async {
 			defer errOut.Close()
 			defer outOut.Close()
 		}
-		outIn.Close()
-		errIn.Close()
-		if r.Stdout, err = readString(outOut); err != nil {
-			return err
+		rmu.Lock()
+		r.Finished = time.Now()
+		if stdoutBuf != nil {
+			r.Stdout = stdoutBuf.String()
 		}
-		if r.Stderr, err = readString(errOut); err != nil {
-			return err
+		if stderrBuf != nil {
+			r.Stderr = stderrBuf.String()
 		}
+		r.Truncated = outCap.truncated || errCap.truncated
 		r.PID = s.Pid()
 		e := s.Sys().(syscall.WaitStatus)
 		r.RC = e.ExitStatus()
 		r.UserTime = s.UserTime()
+		final := r
+		rmu.Unlock()
 		if c.Async {
-			rt <- r
+			rt <- final
 		}
 		return nil
 	}
 
+	// watch blocks until the process exits (naturally or via interruption),
+	// so it belongs on the same side of the Async branch as finisher: run
+	// both in the background goroutine for an async command, or both inline
+	// otherwise, so RunAsync still returns immediately.
+	recordWatch := func() {
+		interrupted, killed, sig := c.watch(ctx, p, waited)
+		rmu.Lock()
+		r.Interrupted = interrupted
+		r.Killed = killed
+		r.Signal = sig
+		rmu.Unlock()
+	}
 	if c.Async {
-		go finisher()
+		go func() {
+			recordWatch()
+			finisher()
+		}()
 	} else {
+		recordWatch()
 		err = finisher()
 	}
 	return err
 }
 
+// capWriter writes up to max bytes to w, discarding (but still accepting,
+// so the upstream io.Copy doesn't block or error) anything beyond that and
+// recording that truncation occurred. max <= 0 means unbounded. A capWriter
+// may be shared between two concurrent io.Copy goroutines (stdout and
+// stderr piped into one CombinedOutput sink), so Write locks mtx.
+type capWriter struct {
+	mtx       sync.Mutex
+	w         io.Writer
+	max       int64
+	n         int64
+	truncated bool
+}
+
+func (c *capWriter) Write(p []byte) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.max <= 0 {
+		_, err := c.w.Write(p)
+		return len(p), err
+	}
+	allowed := c.max - c.n
+	if allowed <= 0 {
+		c.truncated = true
+		return len(p), nil
+	}
+	if int64(len(p)) > allowed {
+		c.truncated = true
+		p = p[:allowed]
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return len(p), err
+}
+
+// watch blocks until either the process exits on its own (waited fires) or
+// ctx is done, in which case it interrupts the process: SIGINT, then
+// SIGKILL if it hasn't exited within the grace period. It always returns
+// once the process has actually exited, so callers can safely read waited
+// again to get the final *os.ProcessState. It reports the outcome by return
+// value rather than writing through a shared *Runtime, since for an async
+// command it runs on a different goroutine than the one that will read
+// those fields back out.
+func (c Command) watch(ctx context.Context, p *os.Process, waited chan *os.ProcessState) (interrupted, killed bool, sig syscall.Signal) {
+	select {
+	case s := <-waited:
+		waited <- s
+		return
+	case <-ctx.Done():
+	}
+
+	grace := c.GracePeriod
+	if grace <= 0 {
+		grace = defaultGrace
+	}
+
+	interrupted = true
+	sig = syscall.SIGINT
+	p.Signal(syscall.SIGINT)
+
+	select {
+	case s := <-waited:
+		waited <- s
+	case <-time.After(grace):
+		killed = true
+		sig = syscall.SIGKILL
+		p.Signal(syscall.SIGKILL)
+	}
+	return
+}
+
+// argv resolves the executable path and the argv to run it with, honoring
+// Command.Args as a bypass for template rendering.
+func (c Command) argv(params ...Param) (string, []string, error) {
+	path, err := exec.LookPath(c.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	argv := c.Args
+	if argv == nil {
+		if argv, err = c.Render(params...); err != nil {
+			return "", nil, err
+		}
+	}
+	return path, argv, nil
+}
+
 func (c Command) Run(params ...Param) (Runtime, error) {
 	rt := make(chan Runtime, 1)
 	err := c.Runner(rt, params...)
 	return <-rt, err
 }
 
+// RunContext runs the command to completion, bounded by ctx and c.Timeout.
+func (c Command) RunContext(ctx context.Context, params ...Param) (Runtime, error) {
+	rt := make(chan Runtime, 1)
+	err := c.RunnerContext(ctx, rt, params...)
+	return <-rt, err
+}
+
 func (c Command) RunAsync(rt chan Runtime, params ...Param) error {
 	c.Async = true
 	return c.Runner(rt, params...)
 }
 
-func (c Command) Background(params ...Param) (int, error) {
-	cmd := exec.Command(c.Path)
-	err := cmd.Start()
-	var pid int
-	if cmd.Process != nil {
-		pid = cmd.Process.Pid
-	}
-	return pid, err
-}
-
-// remove 'optional' syntax and unused optional parameters
-func optional(text string) (string, error) {
+// remove 'optional' syntax and unused optional parameters, then tokenize
+// the result into an argv, expanding any glob tokens in place.
+func optional(text string) ([]string, error) {
 	for {
 		start := strings.Index(text, "[")
 		if start < 0 {
@@ -212,7 +440,7 @@ func optional(text string) (string, error) {
 		}
 		end := strings.Index(text, "]")
 		if end < 0 || end < start {
-			return text, ErrSyntaxError
+			return nil, ErrSyntaxError
 		}
 		if pmatch.MatchString(text) {
 			// remove unused args
@@ -223,18 +451,89 @@ func optional(text string) (string, error) {
 		}
 	}
 	if pmatch.MatchString(text) {
-		return text, ErrIncomplete
+		return nil, ErrIncomplete
 	}
 	text = os.ExpandEnv(text)
-	for _, g := range globs.FindAllString(text, -1) {
-		files, err := filepath.Glob(g)
+
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	argv := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		if !globs.MatchString(tok) {
+			argv = append(argv, tok)
+			continue
+		}
+		files, err := filepath.Glob(tok)
 		if err != nil {
-			return text, err
+			return nil, err
 		}
 		if len(files) == 0 {
-			return text, ErrNoSuchFile
+			return nil, ErrNoSuchFile
 		}
-		text = strings.Replace(text, g, strings.Join(files, " "), -1)
+		argv = append(argv, files...)
+	}
+	return argv, nil
+}
+
+// tokenize splits text into an argv the way a shell would: whitespace
+// separates arguments, single quotes take everything literally, double
+// quotes allow backslash escapes for '"' and '\', and a bare backslash
+// escapes the next character. This is what lets a param like {{MSG}}
+// rendered from Param{"MSG", "hello world"} stay a single argument.
+func tokenize(text string) ([]string, error) {
+	var argv []string
+	var cur strings.Builder
+	inArg := false
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch ch {
+		case ' ', '\t', '\n':
+			if inArg {
+				argv = append(argv, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		case '\'':
+			inArg = true
+			i++
+			for i < len(runes) && runes[i] != '\'' {
+				cur.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, ErrSyntaxError
+			}
+		case '"':
+			inArg = true
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+					i++
+				}
+				cur.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, ErrSyntaxError
+			}
+		case '\\':
+			inArg = true
+			if i+1 >= len(runes) {
+				return nil, ErrSyntaxError
+			}
+			i++
+			cur.WriteRune(runes[i])
+		default:
+			inArg = true
+			cur.WriteRune(ch)
+		}
+	}
+	if inArg {
+		argv = append(argv, cur.String())
 	}
-	return text, nil
+	return argv, nil
 }
@@ -1,11 +1,12 @@
 package cmdr
 
 import (
-	"fmt"
+	"bytes"
 	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 type Brief struct {
@@ -66,6 +67,10 @@ done
 ERR=${1:-23}
 echo >&2 "all I got was a rock"
 exit $ERR
+`
+	noisy = `#!/bin/bash
+echo -n "01234"
+echo -n "56789" >&2
 `
 )
 
@@ -82,6 +87,7 @@ func init() {
 	}
 	ioutil.WriteFile("failure", []byte(failure), 0755)
 	ioutil.WriteFile("forever", []byte(forever), 0755)
+	ioutil.WriteFile("noisy", []byte(noisy), 0755)
 }
 
 func getCmd(t *testing.T, id int64) Command {
@@ -124,13 +130,131 @@ func TestPermutations(t *testing.T) {
 	}
 }
 
+func TestRenderArgv(t *testing.T) {
+	c := Command{Path: "echo", Params: "{{MSG}}"}
+	argv, err := c.Render(Param{"MSG", "hello world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(argv) != 1 || argv[0] != "hello world" {
+		t.Errorf("expected a single quoted argument, got %#v", argv)
+	}
+}
+
+// Quoting a param value only protects whitespace from tokenize; it does not
+// exempt the value from optional's glob expansion, unlike a real shell's
+// double quotes (see Render's doc comment). A param value containing a
+// wildcard that matches nothing still comes back as ErrNoSuchFile.
+func TestRenderArgvWildcardStillGlobs(t *testing.T) {
+	c := Command{Path: "echo", Params: "{{MSG}}"}
+	_, err := c.Render(Param{"MSG", "no *.suchfileextension files"})
+	if err != ErrNoSuchFile {
+		t.Errorf("expected ErrNoSuchFile, got %v", err)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	c := Command{Path: "echo", Args: []string{"hello world"}}
+	r, err := c.Run()
+	if err != nil {
+		t.Error(err)
+	}
+	if strings.TrimSpace(r.Stdout) != "hello world" {
+		t.Errorf("expected %q, got %q", "hello world", r.Stdout)
+	}
+}
+
+func TestStreamingSink(t *testing.T) {
+	var out bytes.Buffer
+	c := Command{Path: "echo", Args: []string{"hi there"}, Stdout: &out}
+	r, err := c.Run()
+	if err != nil {
+		t.Error(err)
+	}
+	if strings.TrimSpace(out.String()) != "hi there" {
+		t.Errorf("expected sink to receive %q, got %q", "hi there", out.String())
+	}
+	if r.Stdout != "" {
+		t.Errorf("expected Runtime.Stdout empty when a sink is supplied, got %q", r.Stdout)
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	c := Command{Path: "echo", Args: []string{"0123456789"}, MaxOutputBytes: 4}
+	r, err := c.Run()
+	if err != nil {
+		t.Error(err)
+	}
+	if !r.Truncated {
+		t.Error("expected Truncated to be true")
+	}
+	if len(r.Stdout) != 4 {
+		t.Errorf("expected 4 bytes of output, got %q", r.Stdout)
+	}
+}
+
+func TestMaxOutputBytesCombined(t *testing.T) {
+	c := Command{Path: "./noisy", MaxOutputBytes: 4, CombinedOutput: true}
+	r, err := c.Run()
+	if err != nil {
+		t.Error(err)
+	}
+	if !r.Truncated {
+		t.Error("expected Truncated to be true")
+	}
+	if len(r.Stdout) != 4 {
+		t.Errorf("expected combined output capped at 4 bytes total, got %d: %q", len(r.Stdout), r.Stdout)
+	}
+}
+
+func TestRunAsyncReturnsImmediately(t *testing.T) {
+	cmd := Command{Path: "sleep", Params: "2"}
+	// Buffered for two: Runner's unconditional deferred send plus the
+	// finisher's send once the child actually exits.
+	rt := make(chan Runtime, 2)
+	start := time.Now()
+	if err := cmd.RunAsync(rt); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("RunAsync blocked for %s, expected near-instant return", elapsed)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	cmd := Command{Path: "sleep", Params: "2", Timeout: 50 * time.Millisecond, GracePeriod: 20 * time.Millisecond}
+	r, err := cmd.Run()
+	if err != nil {
+		t.Error(err)
+	}
+	if !r.Interrupted {
+		t.Error("expected process to be interrupted")
+	}
+	t.Log(r)
+}
+
 func TestBackground(t *testing.T) {
 	cmd := Command{Path: "./forever"}
 	pid, err := cmd.Background()
 	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Background PID: %d", pid)
+
+	var job *Job
+	for _, j := range Jobs() {
+		if j.Status().PID == pid {
+			job = j
+			break
+		}
+	}
+	if job == nil {
+		t.Fatal("no Job registered for Background's pid")
+	}
+	if err := job.Stop(100 * time.Millisecond); err != nil {
 		t.Error(err)
-	} else {
-		// don't let user forget about this!
-		fmt.Printf("\nBackground PID: %d\n\n", pid)
+	}
+	if job.Running() {
+		t.Error("expected job to have stopped")
 	}
 }
@@ -0,0 +1,203 @@
+// Package scripttest is a declarative, testscript-style harness for cmdr.
+// Each test case is a .txtar archive: a header of "key: value" directives
+// describing the command to run and the expected result, followed by
+// zero or more named files to materialize into a per-case tempdir before
+// running it. This lets the command matrix grow by adding archives under
+// testdata/ instead of recompiling Go source.
+package scripttest
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/paulstuart/cmdr"
+)
+
+// errClasses maps an "error:" directive to the cmdr sentinel it names.
+var errClasses = map[string]error{
+	"ErrIncomplete":  cmdr.ErrIncomplete,
+	"ErrUserDenied":  cmdr.ErrUserDenied,
+	"ErrSyntaxError": cmdr.ErrSyntaxError,
+	"ErrNoSuchFile":  cmdr.ErrNoSuchFile,
+	"ErrMustBeRoot":  cmdr.ErrMustBeRoot,
+}
+
+// Case is one command permutation loaded from a .txtar archive.
+type Case struct {
+	Name   string
+	Path   string
+	Params string
+	Bind   []cmdr.Param
+	RC     int
+	Stdout *regexp.Regexp
+	Stderr *regexp.Regexp
+	Err    error
+	Files  map[string]string
+}
+
+// Load parses a single .txtar archive into a Case.
+func Load(path string) (*Case, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	header, files := parseArchive(data)
+
+	c := &Case{
+		Name:  strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Files: files,
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(header))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: malformed directive %q", path, line)
+		}
+		key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "path":
+			c.Path = val
+		case "params":
+			c.Params = val
+		case "param":
+			kv := strings.SplitN(val, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%s: malformed param %q", path, val)
+			}
+			c.Bind = append(c.Bind, cmdr.Param{kv[0], kv[1]})
+		case "rc":
+			rc, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad rc %q: %w", path, val, err)
+			}
+			c.RC = rc
+		case "stdout":
+			if c.Stdout, err = regexp.Compile(val); err != nil {
+				return nil, err
+			}
+		case "stderr":
+			if c.Stderr, err = regexp.Compile(val); err != nil {
+				return nil, err
+			}
+		case "error":
+			e, ok := errClasses[val]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown error class %q", path, val)
+			}
+			c.Err = e
+		default:
+			return nil, fmt.Errorf("%s: unknown directive %q", path, key)
+		}
+	}
+	return c, scanner.Err()
+}
+
+// Exec materializes the case's files into a fresh tempdir, runs the
+// command there, and reports any diff between the expected and actual
+// result via t.
+func (c *Case) Exec(t *testing.T) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "scripttest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, body := range c.Files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := c.Path
+	if _, ok := c.Files[path]; ok {
+		path = filepath.Join(dir, path)
+	}
+
+	cmd := cmdr.Command{Path: path, Params: c.Params}
+	// Only confine the child to the tempdir when the case supplied its own
+	// files: cmdr's glob expansion resolves against the test binary's cwd,
+	// not Command.Dir, so a case with no Files (and thus a Params pattern
+	// meant to glob real files under testdata/) needs the child to share
+	// that same cwd.
+	if len(c.Files) > 0 {
+		cmd.Dir = dir
+	}
+	r, err := cmd.Run(c.Bind...)
+
+	switch {
+	case c.Err == nil && err != nil:
+		t.Errorf("%s: unexpected error: %v", c.Name, err)
+	case c.Err != nil && err == nil:
+		t.Errorf("%s: expected error %v, got none", c.Name, c.Err)
+	case c.Err != nil && err != nil && !strings.HasSuffix(err.Error(), c.Err.Error()):
+		t.Errorf("%s: want error %v, got %v", c.Name, c.Err, err)
+	}
+	if r.RC != c.RC {
+		t.Errorf("%s: want rc %d, got %d", c.Name, c.RC, r.RC)
+	}
+	if c.Stdout != nil && !c.Stdout.MatchString(r.Stdout) {
+		t.Errorf("%s: stdout %q does not match %s", c.Name, r.Stdout, c.Stdout)
+	}
+	if c.Stderr != nil && !c.Stderr.MatchString(r.Stderr) {
+		t.Errorf("%s: stderr %q does not match %s", c.Name, r.Stderr, c.Stderr)
+	}
+}
+
+// Run loads and executes every *.txtar archive under dir, each as its own
+// subtest named after the archive's filename.
+func Run(t *testing.T, dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range matches {
+		m := m
+		c, err := Load(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(c.Name, c.Exec)
+	}
+}
+
+// parseArchive splits a txtar-style archive into its leading directive
+// header and its named file sections, each introduced by a line of the
+// form "-- name --".
+func parseArchive(data []byte) (header string, files map[string]string) {
+	files = make(map[string]string)
+	var name string
+	var head, cur strings.Builder
+
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if trimmed := strings.TrimRight(line, "\r\n"); strings.HasPrefix(trimmed, "-- ") && strings.HasSuffix(trimmed, " --") {
+			if name != "" {
+				files[name] = cur.String()
+				cur.Reset()
+			}
+			name = strings.TrimSpace(trimmed[3 : len(trimmed)-3])
+			continue
+		}
+		if name == "" {
+			head.WriteString(line)
+		} else {
+			cur.WriteString(line)
+		}
+	}
+	if name != "" {
+		files[name] = cur.String()
+	}
+	return head.String(), files
+}
@@ -0,0 +1,7 @@
+package scripttest
+
+import "testing"
+
+func TestArchives(t *testing.T) {
+	Run(t, "testdata")
+}
@@ -0,0 +1,308 @@
+package cmdr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RestartPolicy controls whether a supervised Job is restarted after its
+// process exits.
+type RestartPolicy int
+
+const (
+	Never     RestartPolicy = iota // exit status is recorded, Job does not restart
+	Always                         // always restart, regardless of exit status
+	OnFailure                      // restart only if the process exited non-zero
+)
+
+const (
+	backoffBase    = 500 * time.Millisecond
+	backoffMax     = 30 * time.Second
+	backoffResetAt = 10 * time.Second // runs at least this long reset the backoff
+)
+
+// SuperviseOpts configures how Command.Supervise starts and restarts a
+// long-running process.
+type SuperviseOpts struct {
+	PIDFile        string   // optional; written with the child's pid
+	Stdout, Stderr *os.File // optional; default to /dev/null
+	Restart        RestartPolicy
+	MaxBackoff     time.Duration // caps restart backoff; zero means backoffMax
+}
+
+// Job is a supervised, detached process: it runs in its own session so it
+// outlives the spawning process, is reaped on exit, and is optionally
+// restarted per its RestartPolicy with exponential backoff.
+type Job struct {
+	ID   int64
+	cmd  Command
+	opts SuperviseOpts
+
+	mtx     sync.Mutex
+	process *os.Process
+	last    Runtime
+	running bool
+	attempt int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	jobsMtx sync.Mutex
+	jobs    = map[int64]*Job{}
+)
+
+// Jobs returns every currently registered Job, supervised or finished.
+func Jobs() []*Job {
+	jobsMtx.Lock()
+	defer jobsMtx.Unlock()
+	out := make([]*Job, 0, len(jobs))
+	for _, j := range jobs {
+		out = append(out, j)
+	}
+	return out
+}
+
+// Supervise starts the command as a detached, supervised background
+// process and returns immediately with its Job. Unlike Background, it
+// honors c.Params, c.Dir and c.User, writes a PID file when requested,
+// and reaps the child so its exit status isn't lost.
+func (c Command) Supervise(opts SuperviseOpts, params ...Param) (*Job, error) {
+	j := &Job{
+		ID:   nextID(),
+		cmd:  c,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := j.spawn(params...); err != nil {
+		return nil, err
+	}
+	jobsMtx.Lock()
+	jobs[j.ID] = j
+	jobsMtx.Unlock()
+
+	go j.supervise(params...)
+	return j, nil
+}
+
+// Background starts the command detached from the caller and returns its
+// pid. It is a convenience wrapper around Supervise with RestartPolicy
+// Never; use Supervise directly for PID files, output redirection or
+// restart policies.
+func (c Command) Background(params ...Param) (int, error) {
+	j, err := c.Supervise(SuperviseOpts{Restart: Never}, params...)
+	if err != nil {
+		return 0, err
+	}
+	return j.Status().PID, nil
+}
+
+func (j *Job) spawn(params ...Param) error {
+	path, argv, err := j.cmd.argv(params...)
+	if err != nil {
+		return err
+	}
+
+	stdout := j.opts.Stdout
+	stderr := j.opts.Stderr
+	var devNull *os.File
+	if stdout == nil || stderr == nil {
+		devNull, err = os.OpenFile(os.DevNull, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		defer devNull.Close()
+		if stdout == nil {
+			stdout = devNull
+		}
+		if stderr == nil {
+			stderr = devNull
+		}
+	}
+
+	attr := &os.ProcAttr{
+		Files: []*os.File{nil, stdout, stderr},
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	}
+	if len(j.cmd.Dir) > 0 {
+		attr.Dir = j.cmd.Dir
+	}
+	if len(j.cmd.User) > 0 {
+		if os.Getuid() != 0 {
+			return ErrMustBeRoot
+		}
+		u, err := user.Lookup(j.cmd.User)
+		if err != nil {
+			return err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 0, 32)
+		if err != nil {
+			return err
+		}
+		attr.Sys.Credential = &syscall.Credential{Uid: uint32(uid)}
+	}
+
+	p, err := os.StartProcess(path, append([]string{path}, argv...), attr)
+	if err != nil {
+		return err
+	}
+
+	if j.opts.PIDFile != "" {
+		if err := ioutil.WriteFile(j.opts.PIDFile, []byte(strconv.Itoa(p.Pid)), 0644); err != nil {
+			p.Kill()
+			return err
+		}
+	}
+
+	j.mtx.Lock()
+	j.process = p
+	j.running = true
+	j.last = Runtime{SID: j.ID, PID: p.Pid, Cmd: path, Started: time.Now()}
+	j.mtx.Unlock()
+	return nil
+}
+
+// supervise waits for the child to exit, records its Runtime, and restarts
+// it per j.opts.Restart until Stop is called or the policy says not to.
+func (j *Job) supervise(params ...Param) {
+	for {
+		j.mtx.Lock()
+		p := j.process
+		j.mtx.Unlock()
+
+		s, _ := p.Wait()
+
+		j.mtx.Lock()
+		j.running = false
+		j.last.Finished = time.Now()
+		j.last.PID = s.Pid()
+		if e, ok := s.Sys().(syscall.WaitStatus); ok {
+			j.last.RC = e.ExitStatus()
+		}
+		j.last.UserTime = s.UserTime()
+		ran := j.last.Finished.Sub(j.last.Started)
+		rc := j.last.RC
+		j.mtx.Unlock()
+
+		select {
+		case <-j.stop:
+			close(j.done)
+			return
+		default:
+		}
+
+		restart := false
+		switch j.opts.Restart {
+		case Always:
+			restart = true
+		case OnFailure:
+			restart = rc != 0
+		}
+		if !restart {
+			close(j.done)
+			return
+		}
+
+		j.mtx.Lock()
+		if ran >= backoffResetAt {
+			j.attempt = 0
+		}
+		const maxAttempt = 16 // 500ms<<16 already dwarfs any sane MaxBackoff
+		if j.attempt > maxAttempt {
+			j.attempt = maxAttempt
+		}
+		wait := backoffBase << uint(j.attempt)
+		max := j.opts.MaxBackoff
+		if max <= 0 {
+			max = backoffMax
+		}
+		if wait > max {
+			wait = max
+		}
+		j.attempt++
+		j.mtx.Unlock()
+
+		select {
+		case <-j.stop:
+			close(j.done)
+			return
+		case <-time.After(wait):
+		}
+
+		if err := j.spawn(params...); err != nil {
+			j.mtx.Lock()
+			j.last.Finished = time.Now()
+			j.mtx.Unlock()
+			close(j.done)
+			return
+		}
+	}
+}
+
+// Status returns a snapshot of the Job's most recent Runtime.
+func (j *Job) Status() Runtime {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.last
+}
+
+// Running reports whether the supervised process is currently alive.
+func (j *Job) Running() bool {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.running
+}
+
+// Stop signals the Job to stop restarting, sends SIGINT to the running
+// process (if any), and escalates to SIGKILL if it hasn't exited within
+// grace. It blocks until the process (and its supervising goroutine) has
+// fully exited.
+func (j *Job) Stop(grace time.Duration) error {
+	j.mtx.Lock()
+	select {
+	case <-j.stop:
+		j.mtx.Unlock()
+		<-j.done
+		return nil
+	default:
+		close(j.stop)
+	}
+	p := j.process
+	running := j.running
+	j.mtx.Unlock()
+
+	if running && p != nil {
+		if err := p.Signal(syscall.SIGINT); err != nil {
+			return fmt.Errorf("signal %s: %w", os.Interrupt, err)
+		}
+		select {
+		case <-j.done:
+		case <-time.After(grace):
+			p.Signal(syscall.SIGKILL)
+			<-j.done
+		}
+	} else {
+		<-j.done
+	}
+	return nil
+}
+
+// Signal delivers sig to the Job's process, if it is currently running.
+func (j *Job) Signal(sig os.Signal) error {
+	j.mtx.Lock()
+	p := j.process
+	running := j.running
+	j.mtx.Unlock()
+	if !running || p == nil {
+		return nil
+	}
+	return p.Signal(sig)
+}
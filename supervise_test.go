@@ -0,0 +1,86 @@
+package cmdr
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupervise(t *testing.T) {
+	pidFile, err := ioutil.TempFile("", "cmdr-pid-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pidFile.Close()
+	defer os.Remove(pidFile.Name())
+
+	cmd := Command{Path: "./forever"}
+	j, err := cmd.Supervise(SuperviseOpts{PIDFile: pidFile.Name(), Restart: Never})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !j.Running() {
+		t.Error("expected job to be running")
+	}
+
+	raw, err := ioutil.ReadFile(pidFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != j.Status().PID {
+		t.Errorf("pidfile has %d, job reports %d", pid, j.Status().PID)
+	}
+
+	if err := j.Stop(100 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if j.Running() {
+		t.Error("expected job to have stopped")
+	}
+}
+
+func TestSuperviseOnFailureBackoff(t *testing.T) {
+	cmd := Command{Path: "./failure"}
+	j, err := cmd.Supervise(SuperviseOpts{Restart: OnFailure})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Stop(100 * time.Millisecond)
+
+	type restart struct {
+		pid int
+		at  time.Time
+	}
+	restarts := []restart{{j.Status().PID, time.Now()}}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(restarts) < 3 {
+		time.Sleep(10 * time.Millisecond)
+		if pid := j.Status().PID; pid != 0 && pid != restarts[len(restarts)-1].pid {
+			restarts = append(restarts, restart{pid, time.Now()})
+		}
+	}
+
+	if err := j.Stop(100 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	if len(restarts) < 3 {
+		t.Fatalf("expected at least 2 restarts under OnFailure, saw %d distinct pids: %+v", len(restarts), restarts)
+	}
+
+	gap1 := restarts[1].at.Sub(restarts[0].at)
+	gap2 := restarts[2].at.Sub(restarts[1].at)
+	t.Logf("restart gaps: %s then %s", gap1, gap2)
+	if gap2 <= gap1 {
+		t.Errorf("expected backoff to grow between restarts: gap1=%s gap2=%s", gap1, gap2)
+	}
+}